@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseStatuses parses a comma-separated list of HTTP status codes,
+// as accepted by -simulate-statuses. An empty string yields no
+// statuses, which tells chaosTransport to synthesize a connect error
+// instead of a canned status response.
+func parseStatuses(s string) ([]int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	var statuses []int
+	for _, part := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid status %q: %w", part, err)
+		}
+		statuses = append(statuses, n)
+	}
+	return statuses, nil
+}
+
+// chaosTransport wraps a RoundTripper and, before dispatching,
+// deterministically (given a seed) injects synthetic failures and/or
+// latency. It exists so -simulate-failures can exercise
+// pingWithRetries' backoff+jitter path without needing an unstable
+// upstream.
+type chaosTransport struct {
+	next     http.RoundTripper
+	rate     float64
+	statuses []int
+	latency  time.Duration
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newChaosTransport(next http.RoundTripper, rate float64, statuses []int, latency time.Duration, seed int64) *chaosTransport {
+	return &chaosTransport{
+		next:     next,
+		rate:     rate,
+		statuses: statuses,
+		latency:  latency,
+		rng:      rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (c *chaosTransport) roll() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rng.Float64()
+}
+
+func (c *chaosTransport) pickStatus() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.statuses[c.rng.Intn(len(c.statuses))]
+}
+
+func (c *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c.latency > 0 {
+		select {
+		case <-time.After(c.latency):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	if c.rate > 0 && c.roll() < c.rate {
+		if len(c.statuses) > 0 {
+			status := c.pickStatus()
+			body := fmt.Sprintf("simulated %d", status)
+			return &http.Response{
+				Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+				StatusCode:    status,
+				Proto:         "HTTP/1.1",
+				ProtoMajor:    1,
+				ProtoMinor:    1,
+				Header:        make(http.Header),
+				Body:          io.NopCloser(strings.NewReader(body)),
+				ContentLength: int64(len(body)),
+				Request:       req,
+			}, nil
+		}
+		return nil, fmt.Errorf("simulated failure: connect: connection refused")
+	}
+
+	return c.next.RoundTrip(req)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// roundTrips runs n requests through a chaosTransport built with seed
+// and returns the resulting status codes (0 for a synthetic connect
+// error), so two runs with the same seed can be compared.
+func roundTrips(t *testing.T, seed int64, n int) []int {
+	t.Helper()
+	// rate 1.0 so every request is simulated and ct.next (nil here) is
+	// never dereferenced.
+	ct := newChaosTransport(nil, 1.0, []int{500, 502, 504}, 0, seed)
+	req, err := http.NewRequest(http.MethodGet, "http://example.invalid", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	statuses := make([]int, n)
+	for i := 0; i < n; i++ {
+		resp, err := ct.RoundTrip(req)
+		if err != nil {
+			statuses[i] = 0
+			continue
+		}
+		statuses[i] = resp.StatusCode
+	}
+	return statuses
+}
+
+func TestChaosTransportSameSeedIsReproducible(t *testing.T) {
+	a := roundTrips(t, 42, 200)
+	b := roundTrips(t, 42, 200)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("run diverged at request %d: %d vs %d (seed must make -simulate-failures reproducible)", i, a[i], b[i])
+		}
+	}
+}
+
+func TestChaosTransportDifferentSeedsCanDiverge(t *testing.T) {
+	a := roundTrips(t, 1, 200)
+	b := roundTrips(t, 2, 200)
+	for i := range a {
+		if a[i] != b[i] {
+			return
+		}
+	}
+	t.Fatalf("two different seeds produced identical sequences over %d requests", len(a))
+}
+
+func TestParseStatuses(t *testing.T) {
+	got, err := parseStatuses(" 500, 502 ,504")
+	if err != nil {
+		t.Fatalf("parseStatuses: %v", err)
+	}
+	want := []int{500, 502, 504}
+	if len(got) != len(want) {
+		t.Fatalf("parseStatuses = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("parseStatuses = %v, want %v", got, want)
+		}
+	}
+
+	if _, err := parseStatuses("not-a-number"); err == nil {
+		t.Fatal("parseStatuses(\"not-a-number\") should have errored")
+	}
+
+	if got, err := parseStatuses(""); err != nil || got != nil {
+		t.Fatalf("parseStatuses(\"\") = %v, %v, want nil, nil", got, err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Duration wraps time.Duration so config files can express it as a
+// human-readable string (e.g. "3s") instead of raw nanoseconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// EndpointConfig describes per-URL ping behavior. Zero values mean "fall
+// back to the global flag defaults" everywhere except URL, which is
+// required.
+type EndpointConfig struct {
+	URL         string            `json:"url"`
+	RPS         float64           `json:"rps"`           // requests/sec for this endpoint, 0 = unlimited
+	Burst       int               `json:"burst"`         // token bucket burst size, defaults to 1 if RPS > 0
+	Timeout     Duration          `json:"timeout"`       // per-request timeout override
+	Method      string            `json:"method"`        // HTTP method; empty = HEAD-then-GET fallback
+	Headers     map[string]string `json:"headers"`       // extra request headers
+	MaxInFlight int               `json:"max_in_flight"` // per-endpoint concurrency cap, 0 = unbounded (global sem still applies)
+	Retries     *int              `json:"retries"`       // retry override; nil = use global -retries
+}
+
+// Config is the structured form accepted by -config, as an alternative
+// to the flat URL list accepted by -urls.
+type Config struct {
+	Endpoints []EndpointConfig `json:"endpoints"`
+}
+
+// loadURLsFromFile reads a flat list of URLs, one per line, and turns
+// each into an EndpointConfig with no overrides (the caller applies
+// global flag defaults uniformly).
+func loadURLsFromFile(path string) ([]EndpointConfig, error) {
+	urls, err := loadPlainURLList(path)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]EndpointConfig, 0, len(urls))
+	for _, u := range urls {
+		endpoints = append(endpoints, EndpointConfig{URL: u})
+	}
+	return endpoints, nil
+}
+
+// loadURLsFromConfig reads a JSON config describing per-endpoint rate
+// limits, timeouts, methods and headers.
+func loadURLsFromConfig(path string) ([]EndpointConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cfg Config
+	dec := json.NewDecoder(f)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	for i, ep := range cfg.Endpoints {
+		if ep.URL == "" {
+			return nil, fmt.Errorf("endpoint %d: url is required", i)
+		}
+		if !hasScheme(ep.URL) {
+			cfg.Endpoints[i].URL = "https://" + ep.URL
+		}
+	}
+	if len(cfg.Endpoints) == 0 {
+		return nil, fmt.Errorf("config %s: no endpoints defined", path)
+	}
+	return cfg.Endpoints, nil
+}
+
+func loadPlainURLList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var urls []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !hasScheme(line) {
+			line = "https://" + line
+		}
+		urls = append(urls, line)
+	}
+	return urls, sc.Err()
+}
+
+func hasScheme(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets controls the resolution of the log-scale histogram;
+// 2048 buckets over [minLatencyNanos, maxLatencyNanos] gives roughly
+// 3 significant digits per bucket.
+const (
+	latencyBuckets  = 2048
+	minLatencyNanos = 1_000          // 1µs
+	maxLatencyNanos = 60_000_000_000 // 60s
+)
+
+// Histogram is a lock-free, fixed-size logarithmic latency histogram.
+// It trades exact values for O(1) atomic recording and bounded memory,
+// which matters when thousands of results/sec flow through the
+// collector goroutine.
+type Histogram struct {
+	counts  []int64
+	logBase float64
+}
+
+func newHistogram() *Histogram {
+	return &Histogram{
+		counts:  make([]int64, latencyBuckets+1),
+		logBase: math.Log(float64(maxLatencyNanos)/float64(minLatencyNanos)) / float64(latencyBuckets),
+	}
+}
+
+func (h *Histogram) bucketFor(nanos int64) int {
+	if nanos < minLatencyNanos {
+		nanos = minLatencyNanos
+	}
+	if nanos > maxLatencyNanos {
+		nanos = maxLatencyNanos
+	}
+	idx := int(math.Log(float64(nanos)/float64(minLatencyNanos)) / h.logBase)
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(h.counts) {
+		idx = len(h.counts) - 1
+	}
+	return idx
+}
+
+// bucketUpper returns the upper latency bound represented by bucket i,
+// used as the interpolated value for a percentile falling in it.
+func (h *Histogram) bucketUpper(i int) time.Duration {
+	return time.Duration(float64(minLatencyNanos) * math.Exp(float64(i+1)*h.logBase))
+}
+
+// Record adds d to the histogram. Safe for concurrent use.
+func (h *Histogram) Record(d time.Duration) {
+	atomic.AddInt64(&h.counts[h.bucketFor(d.Nanoseconds())], 1)
+}
+
+// Percentile returns the interpolated latency at p (0-100]. It takes a
+// point-in-time snapshot of the bucket counts, so concurrent Record
+// calls during the walk are reflected in later, not earlier, buckets.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	var total int64
+	snapshot := make([]int64, len(h.counts))
+	for i := range h.counts {
+		snapshot[i] = atomic.LoadInt64(&h.counts[i])
+		total += snapshot[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(p / 100.0 * float64(total)))
+	var cum int64
+	for i, c := range snapshot {
+		cum += c
+		if cum >= target {
+			return h.bucketUpper(i)
+		}
+	}
+	return maxLatencyNanos
+}
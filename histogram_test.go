@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentileUniform(t *testing.T) {
+	h := newHistogram()
+	for i := 1; i <= 100; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	p50 := h.Percentile(50)
+	p99 := h.Percentile(99)
+	if p50 <= 0 || p50 > 60*time.Millisecond {
+		t.Fatalf("p50 = %v, want roughly 50ms", p50)
+	}
+	if p99 < p50 {
+		t.Fatalf("p99 (%v) < p50 (%v), percentiles must be monotonic", p99, p50)
+	}
+	if p99 <= 90*time.Millisecond || p99 > 110*time.Millisecond {
+		t.Fatalf("p99 = %v, want roughly 99ms", p99)
+	}
+}
+
+func TestHistogramPercentileEmpty(t *testing.T) {
+	h := newHistogram()
+	if got := h.Percentile(50); got != 0 {
+		t.Fatalf("Percentile on empty histogram = %v, want 0", got)
+	}
+}
+
+func TestHistogramClampsOutOfRange(t *testing.T) {
+	h := newHistogram()
+	h.Record(0)              // below minLatencyNanos
+	h.Record(24 * time.Hour) // above maxLatencyNanos
+	if got := h.Percentile(50); got <= 0 {
+		t.Fatalf("Percentile after out-of-range records = %v, want > 0", got)
+	}
+	if got := h.Percentile(100); got < maxLatencyNanos {
+		t.Fatalf("Percentile(100) = %v, want >= max bucket", got)
+	}
+}
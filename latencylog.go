@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LatencyLogger writes a (when, url, status, duration_ns) row per
+// result to a CSV file, for offline analysis that a single-pass
+// histogram can't support (arbitrary slicing, joins, re-bucketing).
+type LatencyLogger struct {
+	mu sync.Mutex
+	w  *csv.Writer
+	f  *os.File
+}
+
+func newLatencyLogger(path string) (*LatencyLogger, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"when", "url", "status", "duration_ns"}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.Flush()
+	return &LatencyLogger{w: w, f: f}, nil
+}
+
+// Log appends a row for r. Safe for concurrent use.
+func (l *LatencyLogger) Log(r Result) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write([]string{
+		r.When.Format(time.RFC3339Nano),
+		r.URL,
+		strconv.Itoa(r.Status),
+		strconv.FormatInt(r.Duration.Nanoseconds(), 10),
+	})
+	l.w.Flush()
+}
+
+func (l *LatencyLogger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Flush()
+	return l.f.Close()
+}
@@ -1,17 +1,13 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"flag"
 	"fmt"
-	"math"
 	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
-	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -23,6 +19,7 @@ type Result struct {
 	Duration time.Duration
 	Error    error
 	When     time.Time
+	Attempts int // number of pingOnce calls made, including the first
 }
 
 func newHTTPClient(timeout time.Duration) *http.Client {
@@ -39,20 +36,44 @@ func newHTTPClient(timeout time.Duration) *http.Client {
 	}
 }
 
-func pingOnce(ctx context.Context, client *http.Client, url string) Result {
+// wrapChaos wraps client's Transport in a chaosTransport, used by
+// -simulate-failures to exercise the retry/rate-limit paths against a
+// deterministic synthetic upstream.
+func wrapChaos(client *http.Client, failureRate float64, statuses []int, latency time.Duration, seed int64) *http.Client {
+	wrapped := *client
+	wrapped.Transport = newChaosTransport(client.Transport, failureRate, statuses, latency, seed)
+	return &wrapped
+}
+
+func pingOnce(ctx context.Context, client *http.Client, url, method string, headers map[string]string) Result {
 	start := time.Now()
 
-	// prefer HEAD to reduce payload; fall back to GET if server doesn't like HEAD
-	req, _ := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
-	resp, err := client.Do(req)
-	if err != nil {
-		// try GET as fallback
-		req2, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-		resp, err = client.Do(req2)
+	var resp *http.Response
+	var err error
+
+	if method != "" {
+		req, _ := http.NewRequestWithContext(ctx, method, url, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err = client.Do(req)
+	} else {
+		// prefer HEAD to reduce payload; fall back to GET if server doesn't like HEAD
+		req, _ := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err = client.Do(req)
+		if err != nil {
+			req2, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			for k, v := range headers {
+				req2.Header.Set(k, v)
+			}
+			resp, err = client.Do(req2)
+		}
 	}
 	if resp != nil {
 		// fully close body to allow connection reuse
-		// read at most a bit to avoid leaking connections (not required for HEAD)
 		_ = resp.Body.Close()
 	}
 
@@ -71,15 +92,16 @@ func pingOnce(ctx context.Context, client *http.Client, url string) Result {
 	}
 }
 
-func pingWithRetries(ctx context.Context, client *http.Client, url string, maxRetries int) Result {
+func pingWithRetries(ctx context.Context, client *http.Client, ep EndpointConfig, maxRetries int, backoffBase, backoffMax time.Duration) Result {
 	var last Result
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		// respect context cancellation
 		if ctx.Err() != nil {
-			return Result{URL: url, Error: ctx.Err(), When: time.Now()}
+			return Result{URL: ep.URL, Error: ctx.Err(), When: time.Now(), Attempts: attempt}
 		}
 
-		last = pingOnce(ctx, client, url)
+		last = pingOnce(ctx, client, ep.URL, ep.Method, ep.Headers)
+		last.Attempts = attempt + 1
 		// consider success if no error and status < 500
 		if last.Error == nil && (last.Status == 0 || last.Status < 500) {
 			return last
@@ -87,62 +109,101 @@ func pingWithRetries(ctx context.Context, client *http.Client, url string, maxRe
 
 		// exponential backoff with jitter
 		if attempt < maxRetries {
-			base := float64(100 * (1 << attempt)) // ms
+			base := float64(backoffBase) * float64(int64(1)<<uint(attempt))
+			if backoffMax > 0 && base > float64(backoffMax) {
+				base = float64(backoffMax)
+			}
 			jitter := rand.Float64() * base
-			sleep := time.Duration(base+jitter) * time.Millisecond
+			sleep := time.Duration(base + jitter)
 			select {
 			case <-time.After(sleep):
 			case <-ctx.Done():
-				return Result{URL: url, Error: ctx.Err(), When: time.Now()}
+				return Result{URL: ep.URL, Error: ctx.Err(), When: time.Now(), Attempts: attempt + 1}
 			}
 		}
 	}
 	return last
 }
 
-func loadURLsFromFile(path string) ([]string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// hostStats accumulates summary counters and a latency histogram for
+// a single endpoint.
+type hostStats struct {
+	total, success, failed int64
+	retries                int64
+	dropped                int64
+	latency                *Histogram
+}
+
+func newHostStats() *hostStats {
+	return &hostStats{latency: newHistogram()}
+}
+
+func (h *hostStats) record(r Result) {
+	atomic.AddInt64(&h.total, 1)
+	ok := (r.Error == nil) && (r.Status == 0 || r.Status < 400)
+	if ok {
+		atomic.AddInt64(&h.success, 1)
+	} else {
+		atomic.AddInt64(&h.failed, 1)
 	}
-	defer f.Close()
-	var urls []string
-	sc := bufio.NewScanner(f)
-	for sc.Scan() {
-		line := strings.TrimSpace(sc.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		// ensure scheme
-		if !strings.HasPrefix(line, "http://") && !strings.HasPrefix(line, "https://") {
-			line = "https://" + line
-		}
-		urls = append(urls, line)
+	if r.Attempts > 1 {
+		atomic.AddInt64(&h.retries, int64(r.Attempts-1))
+	}
+	if r.Error == nil {
+		h.latency.Record(r.Duration)
 	}
-	return urls, sc.Err()
+}
+
+func (h *hostStats) recordDrop() {
+	atomic.AddInt64(&h.dropped, 1)
 }
 
 func main() {
 	var (
 		urlsFile    = flag.String("urls", "urls.txt", "file with URLs (one per line). Lines starting with # ignored")
+		configFile  = flag.String("config", "", "JSON config with per-endpoint rps/burst/timeout/method/headers (overrides -urls if set)")
 		concurrency = flag.Int("concurrency", 50, "max concurrent requests")
-		rate        = flag.Int("rate", 0, "rate limit requests per second (0 = unlimited)")
 		timeout     = flag.Duration("timeout", 5*time.Second, "HTTP request timeout")
-		count       = flag.Int("count", 1, "how many pings per URL")
+		count       = flag.Int("count", 1, "how many ping rounds to run (ignored, runs forever, if -control-addr is set)")
 		interval    = flag.Duration("interval", 2*time.Second, "interval between ping rounds")
 		retries     = flag.Int("retries", 2, "retries on failure (per request)")
+		metricsAddr = flag.String("metrics-addr", "", "if set, serve Prometheus metrics on this address (e.g. :9090)")
+		latencyLog  = flag.String("latency-log", "", "if set, write a CSV of (when, url, status, duration_ns) to this path")
+		controlAddr = flag.String("control-addr", "", "if set, serve the runtime control API (add/remove/pause/reload/stats) on this address and run until killed")
+
+		backoffBase = flag.Duration("retry-backoff-base", 100*time.Millisecond, "base delay for exponential retry backoff")
+		backoffMax  = flag.Duration("retry-backoff-max", 0, "cap on retry backoff delay (0 = unbounded)")
+
+		queueSize = flag.Int("queue-size", 1000, "capacity of the bounded queue in front of the worker pool")
+		overflow  = flag.String("overflow", "drop", "what to do when the queue is full on a new round: drop or block")
+
+		simulateFailures = flag.Bool("simulate-failures", false, "wrap the transport in a fault-injecting RoundTripper, for exercising retry/rate-limit logic")
+		simulateRate     = flag.Float64("simulate-rate", 0.2, "probability [0,1] that a request is replaced with a synthetic failure")
+		simulateStatuses = flag.String("simulate-statuses", "500,502,504", "comma-separated statuses to return on a simulated failure; empty means a synthetic connect error instead")
+		simulateLatency  = flag.Duration("simulate-latency", 0, "extra latency injected before every request when -simulate-failures is set")
+		simulateSeed     = flag.Int64("simulate-seed", 1, "seed for the chaos RNG, so -simulate-failures runs are reproducible")
 	)
 	flag.Parse()
 
-	urls, err := loadURLsFromFile(*urlsFile)
+	var endpoints []EndpointConfig
+	var err error
+	if *configFile != "" {
+		endpoints, err = loadURLsFromConfig(*configFile)
+	} else {
+		endpoints, err = loadURLsFromFile(*urlsFile)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to load urls: %v\n", err)
 		os.Exit(1)
 	}
-	if len(urls) == 0 {
+	if len(endpoints) == 0 {
 		fmt.Fprintln(os.Stderr, "no urls provided")
 		os.Exit(1)
 	}
+	if *overflow != "drop" && *overflow != "block" {
+		fmt.Fprintf(os.Stderr, "invalid -overflow %q: must be drop or block\n", *overflow)
+		os.Exit(1)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	// graceful shutdown on Ctrl+C
@@ -154,138 +215,67 @@ func main() {
 		cancel()
 	}()
 
-	client := newHTTPClient(*timeout)
-
-	// rate limiter token channel (if rate>0)
-	var tokenCh chan struct{}
-	if *rate > 0 {
-		tokenCh = make(chan struct{}, *rate*2)
-		intervalDur := time.Duration(float64(time.Second) / float64(*rate))
-		ticker := time.NewTicker(intervalDur)
-		go func() {
-			defer ticker.Stop()
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-ticker.C:
-					// non-blocking add token
-					select {
-					case tokenCh <- struct{}{}:
-					default:
-					}
-				}
-			}
-		}()
+	var metrics *Metrics
+	if *metricsAddr != "" {
+		metrics = newMetrics()
+		serveMetrics(ctx, *metricsAddr)
 	}
 
-	sem := make(chan struct{}, *concurrency)
-	results := make(chan Result, 1000)
-
-	var wg sync.WaitGroup
-
-	var total int64
-	var success int64
-	var failed int64
-	var sumNanos int64
-	var minLatency int64 = math.MaxInt64
-	var maxLatency int64
-
-	var statsMu sync.Mutex
-
-	// collector
-	go func() {
-		for r := range results {
-			atomic.AddInt64(&total, 1)
-			ok := (r.Error == nil) && (r.Status == 0 || r.Status < 400)
-			if ok {
-				atomic.AddInt64(&success, 1)
-			} else {
-				atomic.AddInt64(&failed, 1)
-			}
-			if r.Error == nil {
-				n := r.Duration.Nanoseconds()
-				atomic.AddInt64(&sumNanos, n)
-				statsMu.Lock()
-				if n < minLatency {
-					minLatency = n
-				}
-				if n > maxLatency {
-					maxLatency = n
-				}
-				statsMu.Unlock()
-				fmt.Printf("[%s] %s %d in %v\n", r.When.Format("15:04:05"), r.URL, r.Status, r.Duration)
-			} else {
-				fmt.Printf("[%s] %s ERROR: %v\n", r.When.Format("15:04:05"), r.URL, r.Error)
-			}
-		}
-	}()
-
-	startAll := time.Now()
-outer:
-	for i := 0; i < *count; i++ {
-		for _, u := range urls {
-			// check cancellation
-			select {
-			case <-ctx.Done():
-				break outer
-			default:
-			}
-
-			wg.Add(1)
-			// workers limited by sem
-			go func(url string) {
-				defer wg.Done()
-				sem <- struct{}{}
-				defer func() { <-sem }()
-
-				// rate limit token
-				if tokenCh != nil {
-					select {
-					case <-ctx.Done():
-						return
-					case <-tokenCh:
-					}
-				}
-
-				res := pingWithRetries(ctx, client, url, *retries)
-				select {
-				case <-ctx.Done():
-					return
-				case results <- res:
-				}
-			}(u)
+	var latencyLogger *LatencyLogger
+	if *latencyLog != "" {
+		latencyLogger, err = newLatencyLogger(*latencyLog)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open latency log: %v\n", err)
+			os.Exit(1)
 		}
+		defer latencyLogger.Close()
+	}
 
-		// wait for this round if we want (optional). We'll wait for all goroutines spawned so far OR sleep interval
-		// Simpler: sleep interval, but still allow CTRL+C to break
-		if i < *count-1 {
-			select {
-			case <-ctx.Done():
-				break outer
-			case <-time.After(*interval):
-			}
+	var chaos *chaosOptions
+	if *simulateFailures {
+		statuses, err := parseStatuses(*simulateStatuses)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -simulate-statuses: %v\n", err)
+			os.Exit(1)
 		}
+		chaos = &chaosOptions{Rate: *simulateRate, Statuses: statuses, Latency: *simulateLatency, Seed: *simulateSeed}
 	}
 
-	wg.Wait()
-	// close results and wait collector to finish
-	close(results)
+	pinger := NewPinger(PingerOptions{
+		Concurrency: *concurrency,
+		Timeout:     *timeout,
+		Retries:     *retries,
+		BackoffBase: *backoffBase,
+		BackoffMax:  *backoffMax,
+		Metrics:     metrics,
+		LatencyLog:  latencyLogger,
+		Chaos:       chaos,
+		QueueSize:   *queueSize,
+		Overflow:    *overflow,
+	})
+	pinger.Seed(endpoints)
 
-	totalDur := time.Since(startAll)
-	// compute averages
-	tot := atomic.LoadInt64(&total)
-	succ := atomic.LoadInt64(&success)
-	fail := atomic.LoadInt64(&failed)
-	var avg time.Duration
-	if tot > 0 && sumNanos > 0 {
-		avg = time.Duration(sumNanos / tot)
+	runCount := *count
+	if *controlAddr != "" {
+		serveAdmin(ctx, *controlAddr, pinger)
+		runCount = 0 // run until ctx is cancelled
 	}
 
+	startAll := time.Now()
+	pinger.Run(ctx, runCount, *interval)
+	totalDur := time.Since(startAll)
+
 	fmt.Println("---- summary ----")
-	fmt.Printf("requests: %d, success: %d, failed: %d\n", tot, succ, fail)
-	if tot > 0 {
-		fmt.Printf("avg latency: %v, min: %v, max: %v\n", avg, time.Duration(minLatency), time.Duration(maxLatency))
+	snap := pinger.Snapshot()
+	for _, ep := range snap.Endpoints {
+		if ep.Requests == 0 {
+			continue
+		}
+		fmt.Printf("%s: requests: %d, success: %d, failed: %d, retries: %d, dropped: %d\n", ep.URL, ep.Requests, ep.Success, ep.Failed, ep.Retries, ep.Dropped)
+		fmt.Printf("  p50: %s, p90: %s, p95: %s, p99: %s, p99.9: %s\n", ep.P50, ep.P90, ep.P95, ep.P99, ep.P999)
 	}
+	o := snap.Overall
+	fmt.Printf("overall: requests: %d, success: %d, failed: %d, retries: %d, dropped: %d\n", o.Requests, o.Success, o.Failed, o.Retries, o.Dropped)
+	fmt.Printf("  p50: %s, p90: %s, p95: %s, p99: %s, p99.9: %s\n", o.P50, o.P90, o.P95, o.P99, o.P999)
 	fmt.Printf("total runtime: %v\n", totalDur)
 }
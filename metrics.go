@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the Prometheus collectors the collector goroutine
+// observes into alongside its stdout printing.
+type Metrics struct {
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	retries  *prometheus.CounterVec
+	dropped  *prometheus.CounterVec
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		requests: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "pingmanage_requests_total",
+			Help: "Total ping requests, labeled by url and status class (2xx, 3xx, 4xx, 5xx, error).",
+		}, []string{"url", "status_class"}),
+		errors: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "pingmanage_errors_total",
+			Help: "Total ping errors, labeled by url and error kind (timeout, dns, tls, connect, other).",
+		}, []string{"url", "kind"}),
+		duration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "pingmanage_request_duration_seconds",
+			Help:    "Ping request duration in seconds, labeled by url.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"url"}),
+		retries: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "pingmanage_retries_total",
+			Help: "Total retry attempts made beyond the first, labeled by url.",
+		}, []string{"url"}),
+		dropped: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "pingmanage_dropped_total",
+			Help: "Total ping requests dropped because the bounded queue was full, labeled by url.",
+		}, []string{"url"}),
+	}
+}
+
+// observeDrop records a request dropped at enqueue time because the
+// queue was full under -overflow=drop.
+func (m *Metrics) observeDrop(url string) {
+	if m == nil {
+		return
+	}
+	m.dropped.WithLabelValues(url).Inc()
+}
+
+// observe records a single Result. It's called from the collector
+// goroutine right next to the existing stdout printing.
+func (m *Metrics) observe(r Result) {
+	if m == nil {
+		return
+	}
+	m.duration.WithLabelValues(r.URL).Observe(r.Duration.Seconds())
+	if r.Attempts > 1 {
+		m.retries.WithLabelValues(r.URL).Add(float64(r.Attempts - 1))
+	}
+	if r.Error != nil {
+		m.errors.WithLabelValues(r.URL, classifyError(r.Error)).Inc()
+		m.requests.WithLabelValues(r.URL, "error").Inc()
+		return
+	}
+	m.requests.WithLabelValues(r.URL, statusClass(r.Status)).Inc()
+}
+
+func statusClass(status int) string {
+	switch {
+	case status >= 200 && status < 300:
+		return "2xx"
+	case status >= 300 && status < 400:
+		return "3xx"
+	case status >= 400 && status < 500:
+		return "4xx"
+	case status >= 500:
+		return "5xx"
+	default:
+		return "unknown"
+	}
+}
+
+// classifyError buckets a client.Do error into a coarse kind so the
+// errors_total metric stays low-cardinality.
+func classifyError(err error) string {
+	if err == nil {
+		return "other"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		if opErr.Op == "dial" {
+			return "connect"
+		}
+	}
+	msg := err.Error()
+	for _, sub := range []string{"tls:", "certificate", "x509"} {
+		if strings.Contains(msg, sub) {
+			return "tls"
+		}
+	}
+	for _, sub := range []string{"connection refused", "connect:", "no route to host"} {
+		if strings.Contains(msg, sub) {
+			return "connect"
+		}
+	}
+	return "other"
+}
+
+// serveMetrics starts the Prometheus /metrics endpoint on addr and
+// shuts it down cleanly when ctx is cancelled.
+func serveMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			panic("metrics server: " + err.Error())
+		}
+	}()
+}
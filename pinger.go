@@ -0,0 +1,572 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
+)
+
+// chaosOptions mirrors the -simulate-* flags, carried on PingerOptions
+// so endpoints added later (via Add or Reload) get the same chaos
+// transport as endpoints present at startup.
+type chaosOptions struct {
+	Rate     float64
+	Statuses []int
+	Latency  time.Duration
+	Seed     int64
+}
+
+// PingerOptions holds the process-wide settings that apply uniformly
+// to every endpoint, as opposed to the per-endpoint overrides carried
+// on EndpointConfig.
+type PingerOptions struct {
+	Concurrency int
+	Timeout     time.Duration
+	Retries     int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	Metrics     *Metrics
+	LatencyLog  *LatencyLogger
+	Chaos       *chaosOptions
+
+	// QueueSize bounds the queue between round scheduling and the
+	// worker pool. Overflow is "drop" (count and discard the newest
+	// request) or "block" (apply backpressure to the scheduler).
+	QueueSize int
+	Overflow  string
+}
+
+// endpointState is everything built from an EndpointConfig: the
+// client, limiter and in-flight cap it needs, plus its running stats.
+type endpointState struct {
+	cfg      EndpointConfig
+	client   *http.Client
+	limiter  *rate.Limiter
+	inFlight chan struct{}
+	retries  int
+	stats    *hostStats
+}
+
+type cmdKind int
+
+const (
+	cmdAdd cmdKind = iota
+	cmdRemove
+	cmdPause
+	cmdResume
+	cmdReload
+)
+
+type adminCmd struct {
+	kind       cmdKind
+	endpoint   EndpointConfig
+	url        string
+	configPath string
+	reply      chan error
+}
+
+// Pinger owns the endpoint set, worker pool and rate limiters that
+// used to be local variables in main. Mutating operations (Add,
+// Remove, Reload, Pause, Resume) are serialized through a command
+// channel so a long-running instance can be reconfigured from the
+// admin HTTP server without the ping-round scheduler and the HTTP
+// handlers racing on the same maps.
+type Pinger struct {
+	opts PingerOptions
+
+	mu     sync.RWMutex
+	order  []string
+	states map[string]*endpointState
+
+	commands chan adminCmd
+	done     chan struct{} // closed once processCommands stops accepting, so command senders don't block forever
+	paused   int32
+
+	chaosSeed int64
+
+	overall *hostStats
+	// sem caps in-flight requests at opts.Concurrency; queue is the
+	// bounded buffer in front of it, so a burst of scheduled pings
+	// backs up in the queue instead of each spawning a goroutine that
+	// blocks on a channel-based semaphore (which conflated "how many
+	// run at once" with "how many are waiting").
+	sem     *semaphore.Weighted
+	queue   chan *endpointState
+	results chan Result
+}
+
+func NewPinger(opts PingerOptions) *Pinger {
+	queueSize := opts.QueueSize
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+	return &Pinger{
+		opts:     opts,
+		states:   make(map[string]*endpointState),
+		commands: make(chan adminCmd),
+		done:     make(chan struct{}),
+		overall:  newHostStats(),
+		sem:      semaphore.NewWeighted(int64(opts.Concurrency)),
+		queue:    make(chan *endpointState, queueSize),
+		results:  make(chan Result, 1000),
+	}
+}
+
+// buildState constructs a fresh endpointState for ep, reusing prior
+// (if any) so stats survive an Add-after-Remove or a Reload that only
+// changes rate/timeout settings.
+func (p *Pinger) buildState(ep EndpointConfig, prior *hostStats) *endpointState {
+	client := p.opts.httpClientFor(ep)
+	if p.opts.Chaos != nil {
+		seed := p.opts.Chaos.Seed + atomic.AddInt64(&p.chaosSeed, 1) - 1
+		client = wrapChaos(client, p.opts.Chaos.Rate, p.opts.Chaos.Statuses, p.opts.Chaos.Latency, seed)
+	}
+
+	st := &endpointState{cfg: ep, client: client, retries: p.opts.Retries}
+	if ep.Retries != nil {
+		st.retries = *ep.Retries
+	}
+	if ep.RPS > 0 {
+		burst := ep.Burst
+		if burst <= 0 {
+			burst = 1
+		}
+		st.limiter = rate.NewLimiter(rate.Limit(ep.RPS), burst)
+	}
+	if ep.MaxInFlight > 0 {
+		st.inFlight = make(chan struct{}, ep.MaxInFlight)
+	}
+	if prior != nil {
+		st.stats = prior
+	} else {
+		st.stats = newHostStats()
+	}
+	return st
+}
+
+// closeIdleConnections releases the idle keep-alive connections held
+// by an endpointState's client before it's discarded, so a
+// long-running, repeatedly Add/Remove/Reload'd instance doesn't
+// accumulate an idle connection pool per replaced transport. Chaos
+// clients wrap the real transport in a chaosTransport, so unwrap that
+// to find the thing that actually holds connections.
+func closeIdleConnections(st *endpointState) {
+	if st == nil || st.client == nil {
+		return
+	}
+	t := st.client.Transport
+	if ct, ok := t.(*chaosTransport); ok {
+		t = ct.next
+	}
+	if closer, ok := t.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+func (opts *PingerOptions) httpClientFor(ep EndpointConfig) *http.Client {
+	if ep.Timeout > 0 {
+		return newHTTPClient(time.Duration(ep.Timeout))
+	}
+	return newHTTPClient(opts.Timeout)
+}
+
+// Seed loads the initial endpoint set. Must be called before Run.
+func (p *Pinger) Seed(endpoints []EndpointConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, ep := range endpoints {
+		if _, exists := p.states[ep.URL]; !exists {
+			p.order = append(p.order, ep.URL)
+		}
+		p.states[ep.URL] = p.buildState(ep, nil)
+	}
+}
+
+// --- exported control API, backed by the command channel ---
+
+// errShuttingDown is returned by the command methods when the pinger
+// has stopped accepting commands (processCommands exited), so a
+// concurrent admin request can't block forever on the unbuffered
+// commands channel.
+var errShuttingDown = fmt.Errorf("pinger is shutting down")
+
+// send delivers cmd to processCommands, or returns false if the
+// pinger is already shutting down and nobody is receiving.
+func (p *Pinger) send(cmd adminCmd) bool {
+	select {
+	case p.commands <- cmd:
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+func (p *Pinger) Add(ep EndpointConfig) error {
+	reply := make(chan error, 1)
+	if !p.send(adminCmd{kind: cmdAdd, endpoint: ep, reply: reply}) {
+		return errShuttingDown
+	}
+	return <-reply
+}
+
+func (p *Pinger) Remove(url string) error {
+	reply := make(chan error, 1)
+	if !p.send(adminCmd{kind: cmdRemove, url: url, reply: reply}) {
+		return errShuttingDown
+	}
+	return <-reply
+}
+
+func (p *Pinger) Pause() {
+	p.send(adminCmd{kind: cmdPause})
+}
+
+func (p *Pinger) Resume() {
+	p.send(adminCmd{kind: cmdResume})
+}
+
+func (p *Pinger) Reload(configPath string) error {
+	reply := make(chan error, 1)
+	if !p.send(adminCmd{kind: cmdReload, configPath: configPath, reply: reply}) {
+		return errShuttingDown
+	}
+	return <-reply
+}
+
+// EndpointSnapshot is the JSON shape of one endpoint's stats, as
+// returned by GET /stats.
+type EndpointSnapshot struct {
+	URL      string `json:"url"`
+	Requests int64  `json:"requests"`
+	Success  int64  `json:"success"`
+	Failed   int64  `json:"failed"`
+	Retries  int64  `json:"retries"`
+	Dropped  int64  `json:"dropped"`
+	P50      string `json:"p50"`
+	P90      string `json:"p90"`
+	P95      string `json:"p95"`
+	P99      string `json:"p99"`
+	P999     string `json:"p99_9"`
+}
+
+// PingerSnapshot is the JSON shape returned by GET /stats.
+type PingerSnapshot struct {
+	Paused    bool               `json:"paused"`
+	Endpoints []EndpointSnapshot `json:"endpoints"`
+	Overall   EndpointSnapshot   `json:"overall"`
+}
+
+func snapshotOf(url string, h *hostStats) EndpointSnapshot {
+	return EndpointSnapshot{
+		URL:      url,
+		Requests: atomic.LoadInt64(&h.total),
+		Success:  atomic.LoadInt64(&h.success),
+		Failed:   atomic.LoadInt64(&h.failed),
+		Retries:  atomic.LoadInt64(&h.retries),
+		Dropped:  atomic.LoadInt64(&h.dropped),
+		P50:      h.latency.Percentile(50).String(),
+		P90:      h.latency.Percentile(90).String(),
+		P95:      h.latency.Percentile(95).String(),
+		P99:      h.latency.Percentile(99).String(),
+		P999:     h.latency.Percentile(99.9).String(),
+	}
+}
+
+// Snapshot reads the current endpoint set and stats. Unlike the
+// mutating operations it doesn't need to go through the command
+// channel: it only takes the read lock, and per-endpoint counters are
+// already safe for concurrent reads via atomics.
+func (p *Pinger) Snapshot() PingerSnapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	snap := PingerSnapshot{
+		Paused:  atomic.LoadInt32(&p.paused) == 1,
+		Overall: snapshotOf("overall", p.overall),
+	}
+	for _, url := range p.order {
+		snap.Endpoints = append(snap.Endpoints, snapshotOf(url, p.states[url].stats))
+	}
+	return snap
+}
+
+// --- command handlers, only ever called from processCommands ---
+
+func (p *Pinger) addLocked(ep EndpointConfig) error {
+	if ep.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	if !hasScheme(ep.URL) {
+		ep.URL = "https://" + ep.URL
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	prior, exists := p.states[ep.URL]
+	var priorStats *hostStats
+	if exists {
+		priorStats = prior.stats
+		closeIdleConnections(prior)
+	} else {
+		p.order = append(p.order, ep.URL)
+	}
+	p.states[ep.URL] = p.buildState(ep, priorStats)
+	return nil
+}
+
+func (p *Pinger) removeLocked(url string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st, ok := p.states[url]
+	if !ok {
+		return fmt.Errorf("no such endpoint: %s", url)
+	}
+	closeIdleConnections(st)
+	delete(p.states, url)
+	for i, u := range p.order {
+		if u == url {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// reloadLocked diffs the config at path against the current state and
+// only rebuilds endpoints whose config actually changed.
+func (p *Pinger) reloadLocked(path string) error {
+	endpoints, err := loadURLsFromConfig(path)
+	if err != nil {
+		return err
+	}
+	wanted := make(map[string]EndpointConfig, len(endpoints))
+	for _, ep := range endpoints {
+		wanted[ep.URL] = ep
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for url, st := range p.states {
+		if _, ok := wanted[url]; !ok {
+			closeIdleConnections(st)
+			delete(p.states, url)
+			for i, u := range p.order {
+				if u == url {
+					p.order = append(p.order[:i], p.order[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	for _, ep := range endpoints {
+		existing, ok := p.states[ep.URL]
+		if ok && reflect.DeepEqual(existing.cfg, ep) {
+			continue // unchanged, leave its limiter/client/stats alone
+		}
+		var priorStats *hostStats
+		if ok {
+			priorStats = existing.stats
+			closeIdleConnections(existing)
+		} else {
+			p.order = append(p.order, ep.URL)
+		}
+		p.states[ep.URL] = p.buildState(ep, priorStats)
+	}
+	return nil
+}
+
+func (p *Pinger) processCommands(ctx context.Context) {
+	defer close(p.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cmd := <-p.commands:
+			switch cmd.kind {
+			case cmdAdd:
+				cmd.reply <- p.addLocked(cmd.endpoint)
+			case cmdRemove:
+				cmd.reply <- p.removeLocked(cmd.url)
+			case cmdReload:
+				cmd.reply <- p.reloadLocked(cmd.configPath)
+			case cmdPause:
+				atomic.StoreInt32(&p.paused, 1)
+			case cmdResume:
+				atomic.StoreInt32(&p.paused, 0)
+			}
+		}
+	}
+}
+
+// snapshotStates returns a stable, ordered copy of the current
+// endpoint states for the round scheduler and for Snapshot to read
+// without holding the lock for the duration of a round.
+func (p *Pinger) snapshotStates() []*endpointState {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]*endpointState, 0, len(p.order))
+	for _, url := range p.order {
+		out = append(out, p.states[url])
+	}
+	return out
+}
+
+// consumeResults drains p.results, updating per-endpoint and overall
+// stats, Prometheus metrics and the latency log, plus the existing
+// stdout printing.
+func (p *Pinger) consumeResults() {
+	for r := range p.results {
+		p.mu.RLock()
+		st, ok := p.states[r.URL]
+		p.mu.RUnlock()
+		if ok {
+			st.stats.record(r)
+		}
+		p.overall.record(r)
+		p.opts.Metrics.observe(r)
+		p.opts.LatencyLog.Log(r)
+		if r.Error == nil {
+			fmt.Printf("[%s] %s %d in %v\n", r.When.Format("15:04:05"), r.URL, r.Status, r.Duration)
+		} else {
+			fmt.Printf("[%s] %s ERROR: %v\n", r.When.Format("15:04:05"), r.URL, r.Error)
+		}
+	}
+}
+
+// scheduleRound enqueues one ping per currently known endpoint. When
+// the queue is full, it either drops the request (counting it) or
+// blocks until room frees up, per opts.Overflow.
+func (p *Pinger) scheduleRound(ctx context.Context) {
+	for _, st := range p.snapshotStates() {
+		p.enqueue(ctx, st)
+	}
+}
+
+func (p *Pinger) enqueue(ctx context.Context, st *endpointState) {
+	if p.opts.Overflow == "block" {
+		select {
+		case p.queue <- st:
+		case <-ctx.Done():
+		}
+		return
+	}
+	select {
+	case p.queue <- st:
+	default:
+		st.stats.recordDrop()
+		p.overall.recordDrop()
+		p.opts.Metrics.observeDrop(st.cfg.URL)
+	}
+}
+
+// dispatch pulls queued requests and fans them out to the worker
+// pool, gated by sem. It exits once the queue is closed and drained.
+// wg tracks every spawned execute goroutine so Run can wait for them
+// to actually finish, not just be scheduled, before shutting down.
+func (p *Pinger) dispatch(ctx context.Context, wg *sync.WaitGroup) {
+	for st := range p.queue {
+		wg.Add(1)
+		go func(st *endpointState) {
+			defer wg.Done()
+			p.execute(ctx, st)
+		}(st)
+	}
+}
+
+func (p *Pinger) execute(ctx context.Context, st *endpointState) {
+	if err := p.sem.Acquire(ctx, 1); err != nil {
+		return
+	}
+	defer p.sem.Release(1)
+
+	if st.inFlight != nil {
+		select {
+		case st.inFlight <- struct{}{}:
+			defer func() { <-st.inFlight }()
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	if st.limiter != nil {
+		if err := st.limiter.Wait(ctx); err != nil {
+			return
+		}
+	}
+
+	res := pingWithRetries(ctx, st.client, st.cfg, st.retries, p.opts.BackoffBase, p.opts.BackoffMax)
+	select {
+	case <-ctx.Done():
+		return
+	case p.results <- res:
+	}
+}
+
+// Run drives ping rounds every interval until ctx is cancelled, or
+// until count rounds have been spawned if count > 0. Admin commands
+// (Add/Remove/Pause/Resume/Reload) are processed concurrently with
+// round scheduling via processCommands.
+func (p *Pinger) Run(ctx context.Context, count int, interval time.Duration) {
+	collectorDone := make(chan struct{})
+	go func() {
+		p.consumeResults()
+		close(collectorDone)
+	}()
+	go p.processCommands(ctx)
+
+	var wg sync.WaitGroup
+	dispatchDone := make(chan struct{})
+	go func() {
+		p.dispatch(ctx, &wg)
+		close(dispatchDone)
+	}()
+
+	round := 0
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-timer.C:
+			if atomic.LoadInt32(&p.paused) == 0 {
+				p.scheduleRound(ctx)
+				round++
+			}
+			if count > 0 && round >= count {
+				break loop
+			}
+			timer.Reset(interval)
+		}
+	}
+
+	// Stop accepting new work, let dispatch hand off whatever's still
+	// queued, then wait for every dispatched execute goroutine to
+	// actually finish before closing results. Acquiring the full
+	// semaphore weight isn't enough here: a goroutine that's been
+	// scheduled with "go" but hasn't reached its own Acquire yet would
+	// get starved forever once the weight is reclaimed out from under
+	// it, since ctx (already cancelled) can't unblock it either.
+	close(p.queue)
+	<-dispatchDone
+
+	wgDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(wgDone)
+	}()
+	select {
+	case <-wgDone:
+	case <-time.After(30 * time.Second):
+	}
+
+	close(p.results)
+	<-collectorDone
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func newTestPinger() *Pinger {
+	return NewPinger(PingerOptions{Concurrency: 1, QueueSize: 1, Overflow: "drop"})
+}
+
+// TestReloadLockedPreservesStatsForUnchangedEndpoint checks that
+// reloadLocked only rebuilds endpoints whose config actually changed,
+// carrying the *hostStats pointer forward for everything else.
+func TestReloadLockedPreservesStatsForUnchangedEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	initial := writeConfig(t, dir, "initial.json", `{"endpoints":[
+		{"url":"https://a.example","rps":1},
+		{"url":"https://b.example","rps":1}
+	]}`)
+
+	p := newTestPinger()
+	endpoints, err := loadURLsFromConfig(initial)
+	if err != nil {
+		t.Fatalf("loadURLsFromConfig: %v", err)
+	}
+	p.Seed(endpoints)
+
+	stateBefore := make(map[string]*endpointState, len(p.states))
+	statsBefore := make(map[string]*hostStats, len(p.states))
+	for url, st := range p.states {
+		stateBefore[url] = st
+		statsBefore[url] = st.stats
+	}
+
+	// same endpoints, b's rps changed, a untouched, c added
+	next := writeConfig(t, dir, "next.json", `{"endpoints":[
+		{"url":"https://a.example","rps":1},
+		{"url":"https://b.example","rps":5},
+		{"url":"https://c.example","rps":1}
+	]}`)
+	if err := p.reloadLocked(next); err != nil {
+		t.Fatalf("reloadLocked: %v", err)
+	}
+
+	if p.states["https://a.example"] != stateBefore["https://a.example"] {
+		t.Error("unchanged endpoint a should be left alone entirely, not rebuilt")
+	}
+	if p.states["https://b.example"] == stateBefore["https://b.example"] {
+		t.Error("changed endpoint b should get a rebuilt endpointState (new client/limiter)")
+	}
+	if p.states["https://b.example"].stats != statsBefore["https://b.example"] {
+		t.Error("changed endpoint b should still carry its *hostStats forward across the rebuild")
+	}
+	if _, ok := p.states["https://c.example"]; !ok {
+		t.Error("newly added endpoint c should be present after reload")
+	}
+	if len(p.order) != 3 {
+		t.Errorf("p.order = %v, want 3 entries", p.order)
+	}
+}
+
+// TestReloadLockedRemovesDroppedEndpoint checks that an endpoint
+// missing from the new config is removed from both states and order.
+func TestReloadLockedRemovesDroppedEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	initial := writeConfig(t, dir, "initial.json", `{"endpoints":[
+		{"url":"https://a.example","rps":1},
+		{"url":"https://b.example","rps":1}
+	]}`)
+	p := newTestPinger()
+	endpoints, err := loadURLsFromConfig(initial)
+	if err != nil {
+		t.Fatalf("loadURLsFromConfig: %v", err)
+	}
+	p.Seed(endpoints)
+
+	next := writeConfig(t, dir, "next.json", `{"endpoints":[
+		{"url":"https://a.example","rps":1}
+	]}`)
+	if err := p.reloadLocked(next); err != nil {
+		t.Fatalf("reloadLocked: %v", err)
+	}
+
+	if _, ok := p.states["https://b.example"]; ok {
+		t.Error("b.example should have been removed from states")
+	}
+	for _, url := range p.order {
+		if url == "https://b.example" {
+			t.Error("b.example should have been removed from order")
+		}
+	}
+	if len(p.order) != 1 || len(p.states) != 1 {
+		t.Errorf("expected exactly one endpoint left, got order=%v states=%v", p.order, p.states)
+	}
+}
+
+// TestRunDrainsFinalRoundBeforeReturning guards against a shutdown
+// race where a ping scheduled in the last round is "go"-spawned but
+// hasn't reached its own semaphore acquire by the time Run decides
+// it's done: every scheduled round must be fully accounted for in the
+// overall stats once Run returns, not just the rounds before the last.
+func TestRunDrainsFinalRoundBeforeReturning(t *testing.T) {
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	const rounds = 5
+	p := NewPinger(PingerOptions{Concurrency: 4, Timeout: 2 * time.Second, QueueSize: rounds, Overflow: "drop"})
+	p.Seed([]EndpointConfig{{URL: srv.URL}})
+
+	p.Run(context.Background(), rounds, 5*time.Millisecond)
+
+	snap := p.Snapshot()
+	if snap.Overall.Requests != rounds {
+		t.Fatalf("overall.Requests = %d, want %d (last round(s) silently dropped by shutdown)", snap.Overall.Requests, rounds)
+	}
+	if got := atomic.LoadInt64(&hits); got != rounds {
+		t.Fatalf("server saw %d requests, want %d", got, rounds)
+	}
+}